@@ -0,0 +1,202 @@
+// Copyright 2009 The freegeoip authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package apiserver
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/libdns/cloudflare"
+	"github.com/libdns/route53"
+)
+
+// CertProvider abstracts how the server obtains and serves TLS
+// certificates, so the HTTPS listener does not need to know whether
+// certs come from static files, autocert, or certmagic.
+type CertProvider interface {
+	// Listen wraps the given TCP listener so that accepted connections
+	// are served with this provider's TLS configuration.
+	Listen(l net.Listener) (net.Listener, error)
+
+	// TLSConfig returns the *tls.Config to use for the HTTPS server.
+	TLSConfig() *tls.Config
+}
+
+// NewCertProvider creates the CertProvider configured by c.CertProvider.
+func NewCertProvider(c *Config) (CertProvider, error) {
+	switch c.CertProvider {
+	case "", "file":
+		return newFileCertProvider(&c.TLS, c.LetsEncryptHosts)
+	case "autocert":
+		return newAutocertProvider(c)
+	case "certmagic":
+		return newCertMagicProvider(c)
+	default:
+		return nil, fmt.Errorf("apiserver: unknown cert provider %q", c.CertProvider)
+	}
+}
+
+// fileCertProvider serves a certificate and key pair built from a
+// TLSConfig: static files by default, or an in-memory self-signed dev
+// cert when TLSConfig.AutoCerts is set.
+type fileCertProvider struct {
+	tlsConfig *tls.Config
+}
+
+func newFileCertProvider(t *TLSConfig, hosts string) (*fileCertProvider, error) {
+	cfg, err := t.Build(hosts)
+	if err != nil {
+		return nil, err
+	}
+	return &fileCertProvider{tlsConfig: cfg}, nil
+}
+
+func (p *fileCertProvider) Listen(l net.Listener) (net.Listener, error) {
+	return tls.NewListener(l, p.TLSConfig()), nil
+}
+
+func (p *fileCertProvider) TLSConfig() *tls.Config {
+	return p.tlsConfig
+}
+
+// autocertProvider obtains certificates from Let's Encrypt using
+// golang.org/x/crypto/acme/autocert, replacing the old rsc/letsencrypt
+// based flow.
+type autocertProvider struct {
+	mgr *autocert.Manager
+}
+
+func newAutocertProvider(c *Config) (*autocertProvider, error) {
+	if c.LetsEncryptHosts == "" {
+		return nil, fmt.Errorf("apiserver: autocert cert provider requires -letsencrypt-hosts")
+	}
+	hosts := strings.Split(c.LetsEncryptHosts, ",")
+	mgr := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(hosts...),
+		Cache:      autocert.DirCache(c.LetsEncryptCacheDir),
+		Email:      c.LetsEncryptEmail,
+	}
+	if c.ACMEDirectoryURL != "" {
+		mgr.Client = &acme.Client{DirectoryURL: c.ACMEDirectoryURL}
+	}
+	return &autocertProvider{mgr: mgr}, nil
+}
+
+func (p *autocertProvider) Listen(l net.Listener) (net.Listener, error) {
+	return tls.NewListener(l, p.TLSConfig()), nil
+}
+
+func (p *autocertProvider) TLSConfig() *tls.Config {
+	return p.mgr.TLSConfig()
+}
+
+// certMagicProvider obtains certificates via github.com/caddyserver/certmagic,
+// adding on-demand TLS, staging/production CA selection, and pluggable
+// storage backends (filesystem or Redis, reusing c.RedisAddr).
+type certMagicProvider struct {
+	cfg *certmagic.Config
+}
+
+func newCertMagicProvider(c *Config) (*certMagicProvider, error) {
+	if c.LetsEncryptHosts == "" {
+		return nil, fmt.Errorf("apiserver: certmagic cert provider requires -letsencrypt-hosts")
+	}
+	ca := certmagic.LetsEncryptProductionCA
+	if c.ACMEDirectoryURL != "" {
+		ca = c.ACMEDirectoryURL
+	}
+	if c.ACMEStaging {
+		ca = certmagic.LetsEncryptStagingCA
+	}
+	hosts := strings.Split(c.LetsEncryptHosts, ",")
+	magic := certmagic.NewDefault()
+	magic.Storage = certMagicStorage(c)
+	magic.OnDemand = &certmagic.OnDemandConfig{
+		DecisionFunc: onDemandHostPolicy(hosts),
+	}
+	issuer := certmagic.ACMEIssuer{
+		CA:     ca,
+		Email:  c.LetsEncryptEmail,
+		Agreed: true,
+	}
+	if c.ACMEDNSProvider != "" {
+		solver, err := dns01Solver(c.ACMEDNSProvider)
+		if err != nil {
+			return nil, err
+		}
+		issuer.DNS01Solver = solver
+	}
+	magic.Issuers = []certmagic.Issuer{certmagic.NewACMEIssuer(magic, issuer)}
+	if err := magic.ManageSync(nil, hosts); err != nil {
+		return nil, fmt.Errorf("apiserver: certmagic failed to manage %v: %v", hosts, err)
+	}
+	return &certMagicProvider{cfg: magic}, nil
+}
+
+// onDemandHostPolicy returns a DecisionFunc that only allows on-demand
+// issuance for names in hosts, the certmagic equivalent of
+// autocert.HostWhitelist.
+func onDemandHostPolicy(hosts []string) func(ctx context.Context, name string) error {
+	return func(ctx context.Context, name string) error {
+		for _, h := range hosts {
+			if h == name {
+				return nil
+			}
+		}
+		return fmt.Errorf("apiserver: host %q is not in -letsencrypt-hosts", name)
+	}
+}
+
+// dns01Solver builds a certmagic DNS-01 solver for the named DNS
+// provider. Each provider reads its own credentials from its usual
+// environment variables (e.g. CF_API_TOKEN for cloudflare, the
+// standard AWS_* vars for route53), matching -acme-dns-provider's
+// documented requirement for provider-specific env vars.
+func dns01Solver(provider string) (*certmagic.DNS01Solver, error) {
+	switch provider {
+	case "cloudflare":
+		return &certmagic.DNS01Solver{
+			DNSManager: certmagic.DNSManager{
+				DNSProvider: &cloudflare.Provider{APIToken: os.Getenv("CF_API_TOKEN")},
+			},
+		}, nil
+	case "route53":
+		return &certmagic.DNS01Solver{
+			DNSManager: certmagic.DNSManager{
+				DNSProvider: &route53.Provider{},
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("apiserver: unknown ACME DNS-01 provider %q", provider)
+	}
+}
+
+func certMagicStorage(c *Config) certmagic.Storage {
+	switch c.ACMEStorage {
+	case "redis":
+		return newRedisCertStorage(c.RedisAddr)
+	case "", "filesystem":
+		return &certmagic.FileStorage{Path: c.LetsEncryptCacheDir}
+	default:
+		return &certmagic.FileStorage{Path: c.LetsEncryptCacheDir}
+	}
+}
+
+func (p *certMagicProvider) Listen(l net.Listener) (net.Listener, error) {
+	return tls.NewListener(l, p.TLSConfig()), nil
+}
+
+func (p *certMagicProvider) TLSConfig() *tls.Config {
+	return p.cfg.TLSConfig()
+}
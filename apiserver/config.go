@@ -9,101 +9,134 @@ import (
 	"io"
 	"log"
 	"os"
+	"sync"
 	"time"
 
+	"golang.org/x/crypto/acme"
+
 	"github.com/fiorix/freegeoip"
 )
 
 // Config is the configuration of the freegeoip server.
 type Config struct {
-	FastOpen            bool   // TCP Fast Open
-	Naggle              bool   // TCP Naggle (buffered, disables TCP_NODELAY)
-	ServerAddr          string // HTTP server addr
-	TLSServerAddr       string // HTTPS server addr
-	TLSCertFile         string
-	TLSKeyFile          string
-	LetsEncrypt         bool
-	LetsEncryptCacheDir string
-	LetsEncryptEmail    string
-	LetsEncryptHosts    string
-	APIPrefix           string
-	CORSOrigin          string
-	ReadTimeout         time.Duration
-	WriteTimeout        time.Duration
-	PublicDir           string
-	DB                  string
-	UpdateInterval      time.Duration
-	RetryInterval       time.Duration
-	UseXForwardedFor    bool
-	Silent              bool
-	LogToStdout         bool
-	LogTimestamp        bool
-	RedisAddr           string
-	RedisTimeout        time.Duration
-	MemcacheAddr        string
-	MemcacheTimeout     time.Duration
-	RateLimitBackend    string
-	RateLimitLimit      uint64
-	RateLimitInterval   time.Duration
-	InternalServerAddr  string
-	UpdatesHost         string
-	LicenseKey          string
-	UserID              string
-	ProductID           string
+	FastOpen             bool   // TCP Fast Open
+	Naggle               bool   // TCP Naggle (buffered, disables TCP_NODELAY)
+	ServerAddr           string // HTTP server addr
+	TLSServerAddr        string // HTTPS server addr
+	TLS                  TLSConfig
+	InternalTLS          TLSConfig
+	LetsEncrypt          bool
+	LetsEncryptCacheDir  string
+	LetsEncryptEmail     string
+	LetsEncryptHosts     string
+	CertProvider         string
+	ACMEDirectoryURL     string
+	ACMEStaging          bool
+	ACMEStorage          string
+	ACMEDNSProvider      string
+	APIPrefix            string
+	CORSOrigin           string
+	ReadTimeout          time.Duration
+	WriteTimeout         time.Duration
+	PublicDir            string
+	DB                   string
+	DBSHA256URL          string
+	DBReloadSignal       bool
+	UpdateInterval       time.Duration
+	RetryInterval        time.Duration
+	UseXForwardedFor     bool
+	Silent               bool
+	LogToStdout          bool
+	LogTimestamp         bool
+	RedisAddr            string
+	RedisTimeout         time.Duration
+	MemcacheAddr         string
+	MemcacheTimeout      time.Duration
+	RateLimitBackend     string
+	RateLimitLimit       uint64
+	RateLimitInterval    time.Duration
+	RateLimitBurst       uint64
+	RateLimitAlgorithm   string
+	RateLimitKeyStrategy string
+	InternalServerAddr   string
+	ReconnectInterval    time.Duration
+	ReusePort            bool
+	ShutdownTimeout      time.Duration
+	UpdatesHost          string
+	LicenseKey           string
+	UserID               string
+	ProductID            string
+	ConfigFile           string
 
 	errorLog  *log.Logger
 	accessLog *log.Logger
+	mu        sync.Mutex // guards hot-reloadable fields: RateLimitLimit, RateLimitInterval, CORSOrigin, Silent
 }
 
 // NewConfig creates and initializes a new Config with default values.
 func NewConfig() *Config {
 	return &Config{
-		FastOpen:            false,
-		Naggle:              false,
-		ServerAddr:          ":8080",
-		TLSCertFile:         "cert.pem",
-		TLSKeyFile:          "key.pem",
-		LetsEncrypt:         false,
-		LetsEncryptCacheDir: ".",
-		LetsEncryptEmail:    "",
-		LetsEncryptHosts:    "",
-		APIPrefix:           "/",
-		CORSOrigin:          "*",
-		ReadTimeout:         30 * time.Second,
-		WriteTimeout:        15 * time.Second,
-		DB:                  freegeoip.MaxMindDB,
-		UpdateInterval:      24 * time.Hour,
-		RetryInterval:       2 * time.Hour,
-		LogTimestamp:        true,
-		RedisAddr:           "localhost:6379",
-		RedisTimeout:        time.Second,
-		MemcacheAddr:        "localhost:11211",
-		MemcacheTimeout:     time.Second,
-		RateLimitBackend:    "redis",
-		RateLimitInterval:   time.Hour,
-		UpdatesHost:         "updates.maxmind.com",
-		ProductID:           "GeoIP2-City",
+		FastOpen:             false,
+		Naggle:               false,
+		ServerAddr:           ":8080",
+		TLS:                  TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", MinVersion: "1.2"},
+		InternalTLS:          TLSConfig{ClientAuth: "none", MinVersion: "1.2"},
+		LetsEncrypt:          false,
+		LetsEncryptCacheDir:  ".",
+		LetsEncryptEmail:     "",
+		LetsEncryptHosts:     "",
+		CertProvider:         "file",
+		ACMEDirectoryURL:     acme.LetsEncryptURL,
+		ACMEStaging:          false,
+		ACMEStorage:          "filesystem",
+		APIPrefix:            "/",
+		CORSOrigin:           "*",
+		ReadTimeout:          30 * time.Second,
+		WriteTimeout:         15 * time.Second,
+		DB:                   freegeoip.MaxMindDB,
+		DBReloadSignal:       true,
+		UpdateInterval:       24 * time.Hour,
+		RetryInterval:        2 * time.Hour,
+		LogTimestamp:         true,
+		RedisAddr:            "localhost:6379",
+		RedisTimeout:         time.Second,
+		MemcacheAddr:         "localhost:11211",
+		MemcacheTimeout:      time.Second,
+		RateLimitBackend:     "redis",
+		RateLimitInterval:    time.Hour,
+		RateLimitAlgorithm:   "fixed-window",
+		RateLimitKeyStrategy: "source-ip",
+		ShutdownTimeout:      15 * time.Second,
+		UpdatesHost:          "updates.maxmind.com",
+		ProductID:            "GeoIP2-City",
 	}
 }
 
 // AddFlags adds configuration flags to the given FlagSet.
 func (c *Config) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.ConfigFile, "config", c.ConfigFile, "Optional YAML or TOML config file; overrides defaults, is overridden by FREEGEOIP_* env vars and flags")
 	fs.BoolVar(&c.Naggle, "tcp-naggle", c.Naggle, "Enable TCP Nagle's algorithm (disables NO_DELAY)")
 	fs.BoolVar(&c.FastOpen, "tcp-fast-open", c.FastOpen, "Enable TCP fast open")
 	fs.StringVar(&c.ServerAddr, "http", c.ServerAddr, "Address in form of ip:port to listen on for HTTP")
 	fs.StringVar(&c.TLSServerAddr, "https", c.TLSServerAddr, "Address in form of ip:port to listen on for HTTPS")
-	fs.StringVar(&c.TLSCertFile, "cert", c.TLSCertFile, "X.509 certificate file for HTTPS server")
-	fs.StringVar(&c.TLSKeyFile, "key", c.TLSKeyFile, "X.509 key file for HTTPS server")
+	c.TLS.AddFlags(fs, "tls")
 	fs.BoolVar(&c.LetsEncrypt, "letsencrypt", c.LetsEncrypt, "Enable automatic TLS using letsencrypt.org")
 	fs.StringVar(&c.LetsEncryptEmail, "letsencrypt-email", c.LetsEncryptEmail, "Optional email to register with letsencrypt (default is anonymous)")
 	fs.StringVar(&c.LetsEncryptHosts, "letsencrypt-hosts", c.LetsEncryptHosts, "Comma separated list of hosts for the certificate (required)")
 	fs.StringVar(&c.LetsEncryptCacheDir, "letsencrypt-cache-dir", c.LetsEncryptCacheDir, "Letsencrypt cache dir (for storing certs)")
+	fs.StringVar(&c.CertProvider, "cert-provider", c.CertProvider, "TLS cert provider: file, autocert, or certmagic")
+	fs.StringVar(&c.ACMEDirectoryURL, "acme-directory-url", c.ACMEDirectoryURL, "ACME directory URL used by the autocert and certmagic cert providers")
+	fs.BoolVar(&c.ACMEStaging, "acme-staging", c.ACMEStaging, "Use the ACME staging CA instead of production (certmagic cert provider only)")
+	fs.StringVar(&c.ACMEStorage, "acme-storage", c.ACMEStorage, "Certificate storage backend for the certmagic cert provider: filesystem or redis")
+	fs.StringVar(&c.ACMEDNSProvider, "acme-dns-provider", c.ACMEDNSProvider, "DNS provider name for ACME DNS-01 challenges (certmagic cert provider only, requires provider-specific env vars)")
 	fs.StringVar(&c.APIPrefix, "api-prefix", c.APIPrefix, "URL prefix for API endpoints")
 	fs.StringVar(&c.CORSOrigin, "cors-origin", c.CORSOrigin, "Comma separated list of CORS origin API endpoints")
 	fs.DurationVar(&c.ReadTimeout, "read-timeout", c.ReadTimeout, "Read timeout for HTTP and HTTPS client conns")
 	fs.DurationVar(&c.WriteTimeout, "write-timeout", c.WriteTimeout, "Write timeout for HTTP and HTTPS client conns")
 	fs.StringVar(&c.PublicDir, "public", c.PublicDir, "Public directory to serve at the {prefix}/ endpoint")
-	fs.StringVar(&c.DB, "db", c.DB, "IP database file or URL")
+	fs.StringVar(&c.DB, "db", c.DB, "IP database file or URL. A file:// URL or bare path loads a local file and disables the auto-updater")
+	fs.StringVar(&c.DBSHA256URL, "db-sha256-url", c.DBSHA256URL, "URL of a .sha256 sidecar to verify a downloaded DB archive before swapping it in")
+	fs.BoolVar(&c.DBReloadSignal, "db-reload-signal", c.DBReloadSignal, "Reload the DB on SIGHUP: re-read a local file, or re-download and re-verify when db-sha256-url is set")
 	fs.DurationVar(&c.UpdateInterval, "update", c.UpdateInterval, "Database update check interval")
 	fs.DurationVar(&c.RetryInterval, "retry", c.RetryInterval, "Max time to wait before retrying to download database")
 	fs.BoolVar(&c.UseXForwardedFor, "use-x-forwarded-for", c.UseXForwardedFor, "Use the X-Forwarded-For header when available (e.g. behind proxy)")
@@ -117,7 +150,14 @@ func (c *Config) AddFlags(fs *flag.FlagSet) {
 	fs.StringVar(&c.RateLimitBackend, "quota-backend", c.RateLimitBackend, "Backend for rate limiter: map, redis, or memcache")
 	fs.Uint64Var(&c.RateLimitLimit, "quota-max", c.RateLimitLimit, "Max requests per source IP per interval; set 0 to turn quotas off")
 	fs.DurationVar(&c.RateLimitInterval, "quota-interval", c.RateLimitInterval, "Quota expiration interval, per source IP querying the API")
+	fs.Uint64Var(&c.RateLimitBurst, "quota-burst", c.RateLimitBurst, "Extra requests allowed above the sustained rate in a single burst; set 0 to disable bursting")
+	fs.StringVar(&c.RateLimitAlgorithm, "quota-algorithm", c.RateLimitAlgorithm, "Rate limit algorithm: fixed-window, sliding-window, or token-bucket")
+	fs.StringVar(&c.RateLimitKeyStrategy, "quota-key", c.RateLimitKeyStrategy, "Rate limit key strategy: source-ip, x-forwarded-for, api-key, or cidr")
 	fs.StringVar(&c.InternalServerAddr, "internal-server", c.InternalServerAddr, "Address in form of ip:port to listen on for metrics and pprof")
+	c.InternalTLS.AddFlags(fs, "internal-tls")
+	fs.DurationVar(&c.ReconnectInterval, "reconnect-interval", c.ReconnectInterval, "Retry interval for transient listener bind errors; set 0 to fail immediately")
+	fs.BoolVar(&c.ReusePort, "reuse-port", c.ReusePort, "Bind listeners with SO_REUSEPORT, for zero-downtime restarts and multi-process binding")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "Max time to wait for in-flight requests to finish when draining on SIGTERM/SIGINT")
 	fs.StringVar(&c.UpdatesHost, "updates-host", c.UpdatesHost, "MaxMind Updates Host")
 	fs.StringVar(&c.LicenseKey, "license-key", c.LicenseKey, "MaxMind License Key (requires user-id)")
 	fs.StringVar(&c.UserID, "user-id", c.UserID, "MaxMind User ID (requires license-key)")
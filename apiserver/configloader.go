@@ -0,0 +1,208 @@
+// Copyright 2009 The freegeoip authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package apiserver
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix is prepended to the upper-snake-case form of each Config
+// field name to build its environment variable, e.g. CORSOrigin
+// becomes FREEGEOIP_CORS_ORIGIN.
+const envPrefix = "FREEGEOIP_"
+
+// LoadFile merges settings from a YAML (.yaml, .yml) or TOML (.toml)
+// file into c, overriding the defaults set by NewConfig. Call it
+// before AddFlags/fs.Parse so that command-line flags still take
+// precedence over the file, per the usual defaults < file < env <
+// flags layering.
+func (c *Config) LoadFile(path string) error {
+	return decodeConfigFile(path, c, true)
+}
+
+// decodeConfigFile reads path and unmarshals it into v, dispatching on
+// its extension like LoadFile. strict rejects unknown TOML keys, which
+// is correct when v is the full Config (LoadFile) but not when v is a
+// partial struct like hotReloadFields being decoded from that same
+// full config file (reloadHotFields): every field Config has and
+// hotReloadFields doesn't would otherwise be flagged as unknown.
+func decodeConfigFile(path string, v interface{}, strict bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("apiserver: failed to read config file %q: %v", path, err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, v); err != nil {
+			return fmt.Errorf("apiserver: failed to parse config file %q: %v", path, err)
+		}
+	case ".toml":
+		meta, err := toml.Decode(string(data), v)
+		if err != nil {
+			return fmt.Errorf("apiserver: failed to parse config file %q: %v", path, err)
+		}
+		if strict {
+			if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+				return fmt.Errorf("apiserver: config file %q has unknown key %q", path, undecoded[0].String())
+			}
+		}
+	default:
+		return fmt.Errorf("apiserver: unsupported config file extension %q, want .yaml, .yml, or .toml", path)
+	}
+	return nil
+}
+
+// LoadEnv overrides any Config field that has a matching FREEGEOIP_*
+// environment variable set, e.g. FREEGEOIP_SERVER_ADDR overrides ServerAddr
+// and FREEGEOIP_CORS_ORIGIN overrides CORSOrigin. Call it after
+// LoadFile and before fs.Parse, so flags still win over env vars.
+func (c *Config) LoadEnv() error {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := envPrefix + toUpperSnakeCase(field.Name)
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setFieldFromString(v.Field(i), val); err != nil {
+			return fmt.Errorf("apiserver: invalid value for env var %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func setFieldFromString(f reflect.Value, val string) error {
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(val)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Uint64:
+		n, err := strconv.ParseUint(val, 10, 64)
+		if err != nil {
+			return err
+		}
+		f.SetUint(n)
+	case reflect.Int64:
+		if f.Type() == reflect.TypeOf(time.Duration(0)) {
+			d, err := time.ParseDuration(val)
+			if err != nil {
+				return err
+			}
+			f.SetInt(int64(d))
+			return nil
+		}
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	default:
+		return fmt.Errorf("unsupported field type %s", f.Kind())
+	}
+	return nil
+}
+
+// toUpperSnakeCase converts a CamelCase Go field name such as
+// "CORSOrigin" into its UPPER_SNAKE_CASE env var suffix, e.g.
+// "CORS_ORIGIN".
+func toUpperSnakeCase(s string) string {
+	var b strings.Builder
+	runes := []rune(s)
+	for i, r := range runes {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			prevLower := runes[i-1] >= 'a' && runes[i-1] <= 'z'
+			nextLower := i+1 < len(runes) && runes[i+1] >= 'a' && runes[i+1] <= 'z'
+			if prevLower || nextLower {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(r)
+	}
+	return strings.ToUpper(b.String())
+}
+
+// hotReloadFields mirrors the subset of Config that WatchHotReload
+// applies on SIGHUP, using pointers so a field left out of the config
+// file decodes as nil ("leave alone") and is distinguishable from one
+// explicitly set to its zero value ("set it to zero"), e.g.
+// ratelimitlimit: 0 in the file must be able to turn quotas back off.
+type hotReloadFields struct {
+	RateLimitLimit    *uint64        `yaml:"ratelimitlimit" toml:"RateLimitLimit"`
+	RateLimitInterval *time.Duration `yaml:"ratelimitinterval" toml:"RateLimitInterval"`
+	CORSOrigin        *string        `yaml:"corsorigin" toml:"CORSOrigin"`
+	Silent            *bool          `yaml:"silent" toml:"Silent"`
+}
+
+// WatchHotReload reloads a small subset of fields — RateLimitLimit,
+// RateLimitInterval, CORSOrigin, and Silent — from the file c was
+// loaded from every time the process receives SIGHUP, without
+// restarting any listener. rl's rate limiter is rebuilt from the
+// updated fields, since Config alone has no effect on one already
+// constructed. Call it after the server is up; it runs until the
+// process exits. It's a no-op if c.ConfigFile is empty.
+func (c *Config) WatchHotReload(rl *RateLimiterHandle) {
+	if c.ConfigFile == "" {
+		return
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		for range ch {
+			if err := c.reloadHotFields(rl); err != nil {
+				c.errorLogger().Println(err)
+			}
+		}
+	}()
+}
+
+// reloadHotFields re-reads c.ConfigFile and applies whichever of
+// RateLimitLimit, RateLimitInterval, CORSOrigin, and Silent it sets,
+// under c.mu, then rebuilds rl from the updated Config so the new
+// values actually take effect.
+func (c *Config) reloadHotFields(rl *RateLimiterHandle) error {
+	fresh := hotReloadFields{}
+	if err := decodeConfigFile(c.ConfigFile, &fresh, false); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	if fresh.RateLimitLimit != nil {
+		c.RateLimitLimit = *fresh.RateLimitLimit
+	}
+	if fresh.RateLimitInterval != nil {
+		c.RateLimitInterval = *fresh.RateLimitInterval
+	}
+	if fresh.CORSOrigin != nil {
+		c.CORSOrigin = *fresh.CORSOrigin
+	}
+	if fresh.Silent != nil {
+		c.Silent = *fresh.Silent
+	}
+	c.mu.Unlock()
+	if rl == nil {
+		return nil
+	}
+	return rl.Reload(c)
+}
@@ -0,0 +1,68 @@
+// Copyright 2009 The freegeoip authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package apiserver
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestToUpperSnakeCase(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"ServerAddr", "SERVER_ADDR"},
+		{"CORSOrigin", "CORS_ORIGIN"},
+		{"DBSHA256URL", "DBSHA256URL"},
+		{"ACMEDNSProvider", "ACMEDNS_PROVIDER"},
+		{"DB", "DB"},
+	}
+	for _, tt := range tests {
+		if got := toUpperSnakeCase(tt.in); got != tt.want {
+			t.Errorf("toUpperSnakeCase(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSetFieldFromString(t *testing.T) {
+	type fields struct {
+		S string
+		B bool
+		U uint64
+		D time.Duration
+	}
+	f := &fields{}
+	v := reflect.ValueOf(f).Elem()
+
+	cases := []struct {
+		field string
+		val   string
+		want  interface{}
+	}{
+		{"S", "hello", "hello"},
+		{"B", "true", true},
+		{"U", "42", uint64(42)},
+		{"D", "90s", 90 * time.Second},
+	}
+	for _, c := range cases {
+		if err := setFieldFromString(v.FieldByName(c.field), c.val); err != nil {
+			t.Fatalf("setFieldFromString(%s, %q): %v", c.field, c.val, err)
+		}
+	}
+	want := fields{S: "hello", B: true, U: 42, D: 90 * time.Second}
+	if *f != want {
+		t.Errorf("fields = %+v, want %+v", *f, want)
+	}
+}
+
+func TestSetFieldFromStringInvalid(t *testing.T) {
+	var b bool
+	v := reflect.ValueOf(&b).Elem()
+	if err := setFieldFromString(v, "not-a-bool"); err == nil {
+		t.Error("setFieldFromString did not error on an invalid bool")
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2009 The freegeoip authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package apiserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fiorix/freegeoip"
+)
+
+// isLocalDBFile reports whether db refers to a local file rather than
+// a URL the auto-updater should poll, i.e. a file:// URL or a bare
+// filesystem path with no http(s) scheme.
+func isLocalDBFile(db string) bool {
+	return !strings.HasPrefix(db, "http://") && !strings.HasPrefix(db, "https://")
+}
+
+// dbPath strips an optional file:// scheme from db, returning the
+// plain filesystem path.
+func dbPath(db string) string {
+	return strings.TrimPrefix(db, "file://")
+}
+
+// dbGeneration pairs a *freegeoip.DB with a reference count covering
+// both the dbManager's own baseline reference and every in-flight
+// caller that Acquired it. The reader is only closed once the count
+// drops to zero, so a reload can never close a reader a request is
+// still using.
+type dbGeneration struct {
+	db   *freegeoip.DB
+	refs int32
+}
+
+// dbManager owns the active *freegeoip.DB reader and swaps it under a
+// mutex, reference-counting each generation so in-flight callers keep
+// using their acquired reader until they release it, even across a
+// reload.
+type dbManager struct {
+	c *Config
+
+	mu  sync.Mutex
+	gen *dbGeneration
+}
+
+// newDBManager opens the configured DB. When c.DB is a local file it
+// skips the MaxMind updates client entirely. When c.DBSHA256URL is
+// also set, it downloads c.DB itself, verifies the archive against
+// the sidecar before ever opening it, and re-checks on the same
+// schedule as the normal updates client (c.UpdateInterval); otherwise
+// it falls back to the built-in update-checking DB with no checksum
+// verification.
+func newDBManager(c *Config) (*dbManager, error) {
+	m := &dbManager{c: c}
+	var db *freegeoip.DB
+	var err error
+	switch {
+	case isLocalDBFile(c.DB):
+		db, err = freegeoip.Open(dbPath(c.DB))
+	case c.DBSHA256URL != "":
+		db, err = m.downloadVerifiedDB()
+	default:
+		db, err = freegeoip.OpenURL(c.DB, c.UpdateInterval, c.RetryInterval)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("apiserver: failed to open DB %q: %v", c.DB, err)
+	}
+	m.gen = &dbGeneration{db: db, refs: 1}
+	return m, nil
+}
+
+// downloadVerifiedDB downloads m.c.DB, verifies it against
+// m.c.DBSHA256URL, saves it to a local cache file, and opens it as a
+// freegeoip.DB. It never returns a DB that failed verification.
+func (m *dbManager) downloadVerifiedDB() (*freegeoip.DB, error) {
+	resp, err := http.Get(m.c.DB)
+	if err != nil {
+		return nil, fmt.Errorf("apiserver: failed to download %s: %v", m.c.DB, err)
+	}
+	defer resp.Body.Close()
+	archive, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("apiserver: failed to read %s: %v", m.c.DB, err)
+	}
+	if err := verifyDBChecksum(archive, m.c.DBSHA256URL); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(os.TempDir(), "freegeoip-db.mmdb")
+	if err := os.WriteFile(path, archive, 0o644); err != nil {
+		return nil, fmt.Errorf("apiserver: failed to save verified DB to %s: %v", path, err)
+	}
+	return freegeoip.Open(path)
+}
+
+// WatchChecksummedUpdates re-downloads and re-verifies m.c.DB every
+// c.UpdateInterval when c.DBSHA256URL is set, swapping in the new DB
+// only if it downloads and verifies cleanly. It's the checksum-aware
+// counterpart to the built-in updates client used when DBSHA256URL is
+// empty. It runs until the process exits.
+func (m *dbManager) WatchChecksummedUpdates() {
+	if m.c.DBSHA256URL == "" || isLocalDBFile(m.c.DB) {
+		return
+	}
+	go func() {
+		for {
+			time.Sleep(m.c.UpdateInterval)
+			db, err := m.downloadVerifiedDB()
+			if err != nil {
+				m.c.errorLogger().Println(err)
+				time.Sleep(m.c.RetryInterval)
+				continue
+			}
+			m.swap(db)
+		}
+	}()
+}
+
+// Acquire returns the currently active DB reader along with a release
+// function the caller must call exactly once when done with it (e.g.
+// via defer), typically at the end of the request it's serving. This
+// keeps a reload from closing a reader that's still in use: the old
+// generation's reader is only closed once every caller that acquired
+// it has released it.
+func (m *dbManager) Acquire() (*freegeoip.DB, func()) {
+	m.mu.Lock()
+	g := m.gen
+	atomic.AddInt32(&g.refs, 1)
+	m.mu.Unlock()
+	return g.db, func() { m.release(g) }
+}
+
+func (m *dbManager) release(g *dbGeneration) {
+	if atomic.AddInt32(&g.refs, -1) == 0 {
+		g.db.Close()
+	}
+}
+
+// Reload re-opens the DB from c.DB and atomically swaps it in, closing
+// the previous reader once no caller holds it anymore. For local
+// files it re-reads from disk; for a URL with DBSHA256URL set it
+// re-downloads and re-verifies. Plain URL-backed DBs already refresh
+// themselves via the auto-updater and can't be reloaded this way.
+func (m *dbManager) Reload() error {
+	var db *freegeoip.DB
+	var err error
+	switch {
+	case isLocalDBFile(m.c.DB):
+		db, err = freegeoip.Open(dbPath(m.c.DB))
+	case m.c.DBSHA256URL != "":
+		db, err = m.downloadVerifiedDB()
+	default:
+		return fmt.Errorf("apiserver: -db-reload-signal only applies to local DB files or DBSHA256URL-verified downloads, not %q", m.c.DB)
+	}
+	if err != nil {
+		return fmt.Errorf("apiserver: failed to reload DB %q: %v", m.c.DB, err)
+	}
+	m.swap(db)
+	return nil
+}
+
+// swap atomically replaces the active DB reader with db. The previous
+// generation's reader is closed once every caller that Acquired it
+// has released it, not immediately, so in-flight requests against it
+// are never interrupted.
+func (m *dbManager) swap(db *freegeoip.DB) {
+	newGen := &dbGeneration{db: db, refs: 1}
+	m.mu.Lock()
+	old := m.gen
+	m.gen = newGen
+	m.mu.Unlock()
+	m.release(old)
+}
+
+// WatchReloadSignal reloads the DB every time the process receives
+// SIGHUP, if c.DBReloadSignal is set. It runs until the process exits.
+func (m *dbManager) WatchReloadSignal() {
+	if !m.c.DBReloadSignal {
+		return
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		for range ch {
+			if err := m.Reload(); err != nil {
+				m.c.errorLogger().Println(err)
+			}
+		}
+	}()
+}
+
+// verifyDBChecksum downloads the SHA256 sidecar at sha256URL and
+// compares it against the SHA256 of archive, returning an error on
+// mismatch. It's used to validate a freshly downloaded DB archive
+// before it replaces the in-memory reader.
+func verifyDBChecksum(archive []byte, sha256URL string) error {
+	resp, err := http.Get(sha256URL)
+	if err != nil {
+		return fmt.Errorf("apiserver: failed to fetch %s: %v", sha256URL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("apiserver: failed to fetch %s: status %s", sha256URL, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("apiserver: failed to read %s: %v", sha256URL, err)
+	}
+	fields := strings.Fields(string(body))
+	if len(fields) == 0 {
+		return fmt.Errorf("apiserver: %s returned an empty checksum", sha256URL)
+	}
+	want := strings.ToLower(fields[0])
+	sum := sha256.Sum256(archive)
+	got := hex.EncodeToString(sum[:])
+	if got != want {
+		return fmt.Errorf("apiserver: checksum mismatch for DB archive: want %s, got %s", want, got)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+// Copyright 2009 The freegeoip authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package apiserver
+
+import "testing"
+
+func TestIsLocalDBFile(t *testing.T) {
+	tests := []struct {
+		db   string
+		want bool
+	}{
+		{"/var/lib/freegeoip/db.mmdb", true},
+		{"file:///var/lib/freegeoip/db.mmdb", true},
+		{"db.mmdb", true},
+		{"http://example.com/db.mmdb", false},
+		{"https://example.com/db.mmdb", false},
+	}
+	for _, tt := range tests {
+		if got := isLocalDBFile(tt.db); got != tt.want {
+			t.Errorf("isLocalDBFile(%q) = %v, want %v", tt.db, got, tt.want)
+		}
+	}
+}
+
+func TestDBPath(t *testing.T) {
+	tests := []struct {
+		db   string
+		want string
+	}{
+		{"file:///var/lib/freegeoip/db.mmdb", "/var/lib/freegeoip/db.mmdb"},
+		{"/var/lib/freegeoip/db.mmdb", "/var/lib/freegeoip/db.mmdb"},
+		{"db.mmdb", "db.mmdb"},
+	}
+	for _, tt := range tests {
+		if got := dbPath(tt.db); got != tt.want {
+			t.Errorf("dbPath(%q) = %q, want %q", tt.db, got, tt.want)
+		}
+	}
+}
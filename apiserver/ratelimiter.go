@@ -0,0 +1,249 @@
+// Copyright 2009 The freegeoip authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package apiserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/throttled/throttled/v2"
+	"github.com/throttled/throttled/v2/store/memstore"
+)
+
+// quotaAlgorithm is implemented by each of the three rate limit
+// algorithms c.RateLimitAlgorithm can select, so rateLimiter.ServeHTTP
+// doesn't need to know which one is in effect.
+type quotaAlgorithm interface {
+	// Allow charges one request against key's quota, reporting whether
+	// it should be rejected plus the usual X-RateLimit-* figures.
+	Allow(key string) (limited bool, limit, remaining int, resetAfter, retryAfter time.Duration, err error)
+}
+
+// rateLimiter wraps a quotaAlgorithm with the key strategy used to
+// derive a quota key from each request, and writes the usual
+// X-RateLimit-* headers, plus Retry-After on a 429, on every response
+// whose quota check succeeds.
+type rateLimiter struct {
+	algo    quotaAlgorithm
+	keyFunc func(*http.Request) string
+}
+
+// newRateLimiter creates a rateLimiter from c.RateLimitBackend,
+// c.RateLimitAlgorithm, c.RateLimitLimit/RateLimitBurst, and
+// c.RateLimitKeyStrategy. Set c.RateLimitLimit to 0 to disable quotas.
+func newRateLimiter(c *Config) (*rateLimiter, error) {
+	if c.RateLimitLimit == 0 {
+		return nil, nil
+	}
+	keyFunc, err := rateLimitKeyFunc(c.RateLimitKeyStrategy)
+	if err != nil {
+		return nil, err
+	}
+	algo, err := newQuotaAlgorithm(c)
+	if err != nil {
+		return nil, err
+	}
+	return &rateLimiter{algo: algo, keyFunc: keyFunc}, nil
+}
+
+// newQuotaAlgorithm builds the quotaAlgorithm selected by
+// c.RateLimitAlgorithm: token-bucket (GCRA, also used when the field is
+// empty), fixed-window, or sliding-window, each running against
+// c.RateLimitBackend.
+func newQuotaAlgorithm(c *Config) (quotaAlgorithm, error) {
+	switch c.RateLimitAlgorithm {
+	case "", "token-bucket":
+		store, err := newGCRAStore(c)
+		if err != nil {
+			return nil, err
+		}
+		quota := throttled.RateQuota{
+			MaxRate:  throttled.PerDuration(int(c.RateLimitLimit), c.RateLimitInterval),
+			MaxBurst: int(c.RateLimitBurst),
+		}
+		rl, err := throttled.NewGCRARateLimiter(store, quota)
+		if err != nil {
+			return nil, fmt.Errorf("apiserver: failed to create rate limiter: %v", err)
+		}
+		return &gcraAlgorithm{rl: rl}, nil
+	case "fixed-window":
+		store, err := newCounterStore(c)
+		if err != nil {
+			return nil, err
+		}
+		return &fixedWindowAlgorithm{
+			store:  store,
+			limit:  int64(c.RateLimitLimit + c.RateLimitBurst),
+			window: c.RateLimitInterval,
+		}, nil
+	case "sliding-window":
+		store, err := newCounterStore(c)
+		if err != nil {
+			return nil, err
+		}
+		return &slidingWindowAlgorithm{
+			store:  store,
+			limit:  int64(c.RateLimitLimit + c.RateLimitBurst),
+			window: c.RateLimitInterval,
+		}, nil
+	default:
+		return nil, fmt.Errorf("apiserver: unknown quota algorithm %q", c.RateLimitAlgorithm)
+	}
+}
+
+// newGCRAStore picks the throttled.GCRAStore implementation for
+// c.RateLimitBackend, backing the token-bucket algorithm.
+func newGCRAStore(c *Config) (throttled.GCRAStore, error) {
+	switch c.RateLimitBackend {
+	case "map":
+		return memstore.New(65536)
+	case "redis":
+		return newRedisGCRAStore(c.RedisAddr, c.RedisTimeout)
+	case "memcache":
+		return newMemcacheGCRAStore(c.MemcacheAddr, c.MemcacheTimeout)
+	default:
+		return nil, fmt.Errorf("apiserver: unknown quota backend %q", c.RateLimitBackend)
+	}
+}
+
+// gcraAlgorithm adapts throttled's GCRA rate limiter, which is a true
+// token-bucket: MaxBurst lets a key spend ahead of its sustained rate.
+type gcraAlgorithm struct {
+	rl throttled.RateLimiter
+}
+
+func (g *gcraAlgorithm) Allow(key string) (bool, int, int, time.Duration, time.Duration, error) {
+	limited, result, err := g.rl.RateLimit(key, 1)
+	if err != nil {
+		return false, 0, 0, 0, 0, err
+	}
+	return limited, result.Limit, result.Remaining, result.ResetAfter, result.RetryAfter, nil
+}
+
+// rateLimitKeyFunc returns the function used to derive a quota key
+// from a request, based on the given strategy name.
+func rateLimitKeyFunc(strategy string) (func(*http.Request) string, error) {
+	switch strategy {
+	case "", "source-ip":
+		return func(r *http.Request) string {
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				return r.RemoteAddr
+			}
+			return host
+		}, nil
+	case "x-forwarded-for":
+		return func(r *http.Request) string {
+			xff := r.Header.Get("X-Forwarded-For")
+			if xff == "" {
+				host, _, _ := net.SplitHostPort(r.RemoteAddr)
+				return host
+			}
+			parts := strings.Split(xff, ",")
+			return strings.TrimSpace(parts[len(parts)-1])
+		}, nil
+	case "api-key":
+		return func(r *http.Request) string {
+			return r.Header.Get("X-Api-Key")
+		}, nil
+	case "cidr":
+		return cidrBucketKey, nil
+	default:
+		return nil, fmt.Errorf("apiserver: unknown quota key strategy %q", strategy)
+	}
+}
+
+// cidrBucketKey groups IPv6 addresses by their /64 prefix, so that a
+// single customer with many addresses in the same block shares one
+// quota, and otherwise falls back to the plain source IP for IPv4.
+func cidrBucketKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.To4() != nil {
+		return host
+	}
+	_, network, err := net.ParseCIDR(ip.String() + "/64")
+	if err != nil {
+		return host
+	}
+	return network.String()
+}
+
+// ServeHTTP enforces the quota for r, writing X-RateLimit-Limit,
+// X-RateLimit-Remaining, X-RateLimit-Reset, and Retry-After (on 429)
+// before invoking next. If rl is nil, quotas are disabled.
+func (rl *rateLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	if rl == nil {
+		next.ServeHTTP(w, r)
+		return
+	}
+	key := rl.keyFunc(r)
+	limited, limit, remaining, resetAfter, retryAfter, err := rl.algo.Allow(key)
+	if err != nil {
+		next.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.Itoa(int(resetAfter/time.Second)))
+	if limited {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter/time.Second)))
+		http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+	next.ServeHTTP(w, r)
+}
+
+// RateLimiterHandle holds the active rateLimiter behind a RWMutex so a
+// SIGHUP config reload can rebuild it in place — picking up a changed
+// RateLimitLimit/Burst/Interval/Algorithm — without restarting any
+// listener. A nil *rateLimiter (quotas disabled) is a valid state.
+type RateLimiterHandle struct {
+	mu sync.RWMutex
+	rl *rateLimiter
+}
+
+// NewRateLimiterHandle builds the initial rateLimiter from c and wraps
+// it in a reloadable handle.
+func NewRateLimiterHandle(c *Config) (*RateLimiterHandle, error) {
+	rl, err := newRateLimiter(c)
+	if err != nil {
+		return nil, err
+	}
+	return &RateLimiterHandle{rl: rl}, nil
+}
+
+// ServeHTTP enforces the quota currently configured, see
+// rateLimiter.ServeHTTP.
+func (h *RateLimiterHandle) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	h.mu.RLock()
+	rl := h.rl
+	h.mu.RUnlock()
+	rl.ServeHTTP(w, r, next)
+}
+
+// Reload rebuilds the rate limiter from c's current
+// RateLimitLimit/RateLimitBurst/RateLimitInterval/RateLimitAlgorithm/
+// RateLimitBackend/RateLimitKeyStrategy and swaps it in, so a hot
+// reload of those fields actually changes enforcement instead of only
+// updating the Config struct.
+func (h *RateLimiterHandle) Reload(c *Config) error {
+	rl, err := newRateLimiter(c)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	h.rl = rl
+	h.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,226 @@
+// Copyright 2009 The freegeoip authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package apiserver
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/gomodule/redigo/redis"
+	"github.com/throttled/throttled/v2"
+	"github.com/throttled/throttled/v2/store/redigostore"
+)
+
+// newRedisGCRAStore adapts the existing Redis quota backend to
+// throttled's GCRAStore interface.
+func newRedisGCRAStore(addr string, timeout time.Duration) (throttled.GCRAStore, error) {
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.DialTimeout("tcp", addr, timeout, timeout, timeout)
+		},
+	}
+	return redigostore.New(pool, "freegeoip:quota:", 0)
+}
+
+// memcacheGCRAStore is a minimal throttled.GCRAStore backed by
+// Memcache, for operators who already run Memcache for other quota
+// backends and don't want to stand up Redis just for rate limiting.
+type memcacheGCRAStore struct {
+	client *memcache.Client
+	prefix string
+}
+
+func newMemcacheGCRAStore(addr string, timeout time.Duration) (*memcacheGCRAStore, error) {
+	client := memcache.New(addr)
+	client.Timeout = timeout
+	return &memcacheGCRAStore{client: client, prefix: "freegeoip:quota:"}, nil
+}
+
+func (s *memcacheGCRAStore) GetWithTime(key string) (int64, time.Time, error) {
+	now := time.Now()
+	item, err := s.client.Get(s.prefix + key)
+	if err == memcache.ErrCacheMiss {
+		return -1, now, nil
+	}
+	if err != nil {
+		return 0, now, err
+	}
+	v, err := strconv.ParseInt(string(item.Value), 10, 64)
+	return v, now, err
+}
+
+func (s *memcacheGCRAStore) SetIfNotExistsWithTTL(key string, value int64, ttl time.Duration) (bool, error) {
+	err := s.client.Add(&memcache.Item{
+		Key:        s.prefix + key,
+		Value:      []byte(strconv.FormatInt(value, 10)),
+		Expiration: int32(ttl.Seconds()),
+	})
+	if err == memcache.ErrNotStored {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *memcacheGCRAStore) CompareAndSwapWithTTL(key string, old, new int64, ttl time.Duration) (bool, error) {
+	item, err := s.client.Get(s.prefix + key)
+	if err != nil {
+		return false, err
+	}
+	cur, err := strconv.ParseInt(string(item.Value), 10, 64)
+	if err != nil {
+		return false, err
+	}
+	if cur != old {
+		return false, nil
+	}
+	item.Value = []byte(strconv.FormatInt(new, 10))
+	item.Expiration = int32(ttl.Seconds())
+	err = s.client.CompareAndSwap(item)
+	if err == memcache.ErrCASConflict || err == memcache.ErrNotStored {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+var _ throttled.GCRAStore = (*memcacheGCRAStore)(nil)
+
+// mapCounterStore is an in-process counterStore for the "map" quota
+// backend, used by the fixed-window and sliding-window algorithms.
+type mapCounterStore struct {
+	mu      sync.Mutex
+	buckets map[string]*counterBucket
+}
+
+type counterBucket struct {
+	count   int64
+	expires time.Time
+}
+
+func newMapCounterStore() *mapCounterStore {
+	return &mapCounterStore{buckets: make(map[string]*counterBucket)}
+}
+
+func (s *mapCounterStore) Incr(bucketKey string, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	b, ok := s.buckets[bucketKey]
+	if !ok || now.After(b.expires) {
+		b = &counterBucket{expires: now.Add(ttl)}
+		s.buckets[bucketKey] = b
+	}
+	b.count++
+	return b.count, nil
+}
+
+func (s *mapCounterStore) Get(bucketKey string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[bucketKey]
+	if !ok || time.Now().After(b.expires) {
+		return 0, nil
+	}
+	return b.count, nil
+}
+
+// redisCounterStore is a Redis-backed counterStore using INCR plus a
+// one-time EXPIRE on bucket creation.
+type redisCounterStore struct {
+	pool   *redis.Pool
+	prefix string
+}
+
+func newRedisCounterStore(addr string, timeout time.Duration) *redisCounterStore {
+	return &redisCounterStore{
+		pool: &redis.Pool{
+			Dial: func() (redis.Conn, error) {
+				return redis.DialTimeout("tcp", addr, timeout, timeout, timeout)
+			},
+		},
+		prefix: "freegeoip:quota:window:",
+	}
+}
+
+func (s *redisCounterStore) Incr(bucketKey string, ttl time.Duration) (int64, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+	count, err := redis.Int64(conn.Do("INCR", s.prefix+bucketKey))
+	if err != nil {
+		return 0, err
+	}
+	if count == 1 {
+		if _, err := conn.Do("EXPIRE", s.prefix+bucketKey, int(ttl.Seconds())); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+func (s *redisCounterStore) Get(bucketKey string) (int64, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+	count, err := redis.Int64(conn.Do("GET", s.prefix+bucketKey))
+	if err == redis.ErrNil {
+		return 0, nil
+	}
+	return count, err
+}
+
+// memcacheCounterStore is a Memcache-backed counterStore using Add
+// plus Increment. Get uses a plain Get on the key, which reads the
+// counter without changing it.
+type memcacheCounterStore struct {
+	client *memcache.Client
+	prefix string
+}
+
+func newMemcacheCounterStore(addr string, timeout time.Duration) *memcacheCounterStore {
+	client := memcache.New(addr)
+	client.Timeout = timeout
+	return &memcacheCounterStore{client: client, prefix: "freegeoip:quota:window:"}
+}
+
+func (s *memcacheCounterStore) Incr(bucketKey string, ttl time.Duration) (int64, error) {
+	key := s.prefix + bucketKey
+	n, err := s.client.Increment(key, 1)
+	if err == memcache.ErrCacheMiss {
+		addErr := s.client.Add(&memcache.Item{
+			Key:        key,
+			Value:      []byte("1"),
+			Expiration: int32(ttl.Seconds()),
+		})
+		if addErr == nil {
+			return 1, nil
+		}
+		if addErr != memcache.ErrNotStored {
+			return 0, addErr
+		}
+		// Lost the race to another Add; fall through to Increment.
+		n, err = s.client.Increment(key, 1)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int64(n), nil
+}
+
+func (s *memcacheCounterStore) Get(bucketKey string) (int64, error) {
+	item, err := s.client.Get(s.prefix + bucketKey)
+	if err == memcache.ErrCacheMiss {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(item.Value), 10, 64)
+}
+
+var (
+	_ counterStore = (*mapCounterStore)(nil)
+	_ counterStore = (*redisCounterStore)(nil)
+	_ counterStore = (*memcacheCounterStore)(nil)
+)
@@ -0,0 +1,129 @@
+// Copyright 2009 The freegeoip authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package apiserver
+
+import (
+	"fmt"
+	"time"
+)
+
+// counterStore is the minimal primitive the fixed-window and
+// sliding-window algorithms need from a quota backend: an atomic
+// increment-with-expiry, and a read-only peek at a bucket that may
+// belong to a previous, already-expired window.
+type counterStore interface {
+	// Incr increments the counter for bucketKey by 1, creating it with
+	// the given ttl if absent, and returns the new count.
+	Incr(bucketKey string, ttl time.Duration) (int64, error)
+	// Get returns the current count for bucketKey without incrementing
+	// it, or 0 if the bucket doesn't exist (e.g. it already expired).
+	Get(bucketKey string) (int64, error)
+}
+
+// newCounterStore picks the counterStore implementation for
+// c.RateLimitBackend, backing the fixed-window and sliding-window
+// algorithms.
+func newCounterStore(c *Config) (counterStore, error) {
+	switch c.RateLimitBackend {
+	case "map":
+		return newMapCounterStore(), nil
+	case "redis":
+		return newRedisCounterStore(c.RedisAddr, c.RedisTimeout), nil
+	case "memcache":
+		return newMemcacheCounterStore(c.MemcacheAddr, c.MemcacheTimeout), nil
+	default:
+		return nil, fmt.Errorf("apiserver: unknown quota backend %q", c.RateLimitBackend)
+	}
+}
+
+// bucketKey returns the counter key for key in the window of the
+// given length that now falls into, along with how long until that
+// window rolls over.
+func bucketKey(key string, window time.Duration, now time.Time) (string, time.Duration) {
+	windowSecs := int64(window / time.Second)
+	if windowSecs <= 0 {
+		windowSecs = 1
+	}
+	idx := now.Unix() / windowSecs
+	elapsed := time.Duration(now.Unix()%windowSecs) * time.Second
+	return fmt.Sprintf("%s:%d", key, idx), window - elapsed
+}
+
+// fixedWindowAlgorithm counts requests in non-overlapping windows of
+// length `window`, resetting the count to zero at each boundary. It's
+// simpler than sliding-window but allows up to 2x limit requests
+// clustered around a window boundary.
+type fixedWindowAlgorithm struct {
+	store  counterStore
+	limit  int64
+	window time.Duration
+}
+
+func (a *fixedWindowAlgorithm) Allow(key string) (bool, int, int, time.Duration, time.Duration, error) {
+	bucket, resetAfter := bucketKey(key, a.window, time.Now())
+	count, err := a.store.Incr(bucket, a.window)
+	if err != nil {
+		return false, 0, 0, 0, 0, err
+	}
+	remaining := a.limit - count
+	if remaining < 0 {
+		remaining = 0
+	}
+	limited := count > a.limit
+	var retryAfter time.Duration
+	if limited {
+		retryAfter = resetAfter
+	}
+	return limited, int(a.limit), int(remaining), resetAfter, retryAfter, nil
+}
+
+// slidingWindowAlgorithm approximates a true sliding window by
+// weighting the previous window's count by how much of it still
+// overlaps the trailing `window` duration, avoiding the fixed-window
+// boundary burst.
+type slidingWindowAlgorithm struct {
+	store  counterStore
+	limit  int64
+	window time.Duration
+}
+
+func (a *slidingWindowAlgorithm) Allow(key string) (bool, int, int, time.Duration, time.Duration, error) {
+	now := time.Now()
+	windowSecs := int64(a.window / time.Second)
+	if windowSecs <= 0 {
+		windowSecs = 1
+	}
+	idx := now.Unix() / windowSecs
+	elapsed := time.Duration(now.Unix()%windowSecs) * time.Second
+	resetAfter := a.window - elapsed
+
+	currBucket := fmt.Sprintf("%s:%d", key, idx)
+	prevBucket := fmt.Sprintf("%s:%d", key, idx-1)
+
+	// Keep each bucket for two windows so it is still readable as the
+	// previous bucket throughout the following window.
+	currCount, err := a.store.Incr(currBucket, 2*a.window)
+	if err != nil {
+		return false, 0, 0, 0, 0, err
+	}
+	prevCount, err := a.store.Get(prevBucket)
+	if err != nil {
+		return false, 0, 0, 0, 0, err
+	}
+
+	weight := 1 - float64(elapsed)/float64(a.window)
+	estimated := float64(prevCount)*weight + float64(currCount)
+
+	limited := estimated > float64(a.limit)
+	remaining := a.limit - int64(estimated)
+	if remaining < 0 {
+		remaining = 0
+	}
+	var retryAfter time.Duration
+	if limited {
+		retryAfter = resetAfter
+	}
+	return limited, int(a.limit), int(remaining), resetAfter, retryAfter, nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2009 The freegeoip authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package apiserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketKey(t *testing.T) {
+	window := 10 * time.Second
+	base := time.Unix(1000, 0)
+
+	tests := []struct {
+		now       time.Time
+		wantKey   string
+		wantReset time.Duration
+	}{
+		{base, "ip:100", 10 * time.Second},
+		{base.Add(3 * time.Second), "ip:100", 7 * time.Second},
+		{base.Add(9 * time.Second), "ip:100", 1 * time.Second},
+		{base.Add(10 * time.Second), "ip:101", 10 * time.Second},
+	}
+	for _, tt := range tests {
+		key, reset := bucketKey("ip", window, tt.now)
+		if key != tt.wantKey || reset != tt.wantReset {
+			t.Errorf("bucketKey(%q, %s, %s) = %q, %s, want %q, %s",
+				"ip", window, tt.now, key, reset, tt.wantKey, tt.wantReset)
+		}
+	}
+}
+
+func TestBucketKeyZeroWindow(t *testing.T) {
+	key, reset := bucketKey("ip", 0, time.Unix(1000, 0))
+	if key != "ip:1000" || reset != 0 {
+		t.Errorf("bucketKey with a zero window = %q, %s, want %q, %s", key, reset, "ip:1000", time.Duration(0))
+	}
+}
+
+func TestSlidingWindowAlgorithmLimit(t *testing.T) {
+	store := newMapCounterStore()
+	a := &slidingWindowAlgorithm{store: store, limit: 5, window: 10 * time.Second}
+
+	for i := 0; i < 5; i++ {
+		if limited, _, _, _, _, err := a.Allow("ip"); err != nil {
+			t.Fatalf("Allow: %v", err)
+		} else if limited {
+			t.Fatalf("Allow reported limited before the limit was reached (request %d)", i+1)
+		}
+	}
+	limited, _, remaining, _, _, err := a.Allow("ip")
+	if err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	if !limited {
+		t.Errorf("Allow did not limit after 6 requests against a limit of 5")
+	}
+	if remaining != 0 {
+		t.Errorf("remaining = %d, want 0 once limited", remaining)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2009 The freegeoip authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package apiserver
+
+import (
+	"context"
+	"io/fs"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/gomodule/redigo/redis"
+)
+
+// redisCertStorage implements certmagic.Storage on top of Redis, so
+// certificates and ACME account data can be shared across replicas of
+// the server that already point RedisAddr at the same instance used
+// for rate limiting.
+type redisCertStorage struct {
+	pool   *redis.Pool
+	prefix string
+}
+
+func newRedisCertStorage(addr string) *redisCertStorage {
+	return &redisCertStorage{
+		pool: &redis.Pool{
+			Dial: func() (redis.Conn, error) {
+				return redis.Dial("tcp", addr)
+			},
+		},
+		prefix: "freegeoip:acme:",
+	}
+}
+
+func (s *redisCertStorage) key(k string) string {
+	return s.prefix + k
+}
+
+func (s *redisCertStorage) Store(ctx context.Context, key string, value []byte) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("SET", s.key(key), value)
+	return err
+}
+
+func (s *redisCertStorage) Load(ctx context.Context, key string) ([]byte, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+	b, err := redis.Bytes(conn.Do("GET", s.key(key)))
+	if err == redis.ErrNil {
+		return nil, fs.ErrNotExist
+	}
+	return b, err
+}
+
+func (s *redisCertStorage) Delete(ctx context.Context, key string) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("DEL", s.key(key))
+	return err
+}
+
+func (s *redisCertStorage) Exists(ctx context.Context, key string) bool {
+	conn := s.pool.Get()
+	defer conn.Close()
+	ok, _ := redis.Bool(conn.Do("EXISTS", s.key(key)))
+	return ok
+}
+
+func (s *redisCertStorage) List(ctx context.Context, prefix string, recursive bool) ([]string, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+	keys, err := redis.Strings(conn.Do("KEYS", s.key(prefix)+"*"))
+	if err != nil {
+		return nil, err
+	}
+	for i, k := range keys {
+		keys[i] = k[len(s.prefix):]
+	}
+	return keys, nil
+}
+
+func (s *redisCertStorage) Stat(ctx context.Context, key string) (certmagic.KeyInfo, error) {
+	b, err := s.Load(ctx, key)
+	if err != nil {
+		return certmagic.KeyInfo{}, err
+	}
+	return certmagic.KeyInfo{
+		Key:        key,
+		Modified:   time.Now(),
+		Size:       int64(len(b)),
+		IsTerminal: true,
+	}, nil
+}
+
+// Lock and Unlock provide a best-effort distributed lock using a Redis
+// SETNX with a short TTL, enough to avoid duplicate ACME issuance
+// across replicas racing on the same key. Lock blocks, retrying with
+// a short backoff, until it acquires the key or ctx is done.
+func (s *redisCertStorage) Lock(ctx context.Context, key string) error {
+	for {
+		conn := s.pool.Get()
+		reply, err := redis.String(conn.Do("SET", s.key("lock:"+key), "1", "NX", "EX", 30))
+		conn.Close()
+		if err == nil && reply == "OK" {
+			return nil
+		}
+		if err != nil && err != redis.ErrNil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
+func (s *redisCertStorage) Unlock(ctx context.Context, key string) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("DEL", s.key("lock:"+key))
+	return err
+}
+
+var _ certmagic.Storage = (*redisCertStorage)(nil)
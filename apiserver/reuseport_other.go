@@ -0,0 +1,17 @@
+// Copyright 2009 The freegeoip authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package apiserver
+
+import "net"
+
+// reuseportListenConfig returns a plain net.ListenConfig on platforms
+// without SO_REUSEPORT support (e.g. Windows). Config.ReusePort is
+// ignored there.
+func reuseportListenConfig() net.ListenConfig {
+	return net.ListenConfig{}
+}
@@ -0,0 +1,139 @@
+// Copyright 2009 The freegeoip authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package apiserver
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// listenerSupervisor owns a set of http.Server instances and their
+// listeners, retrying transient bind failures and draining all of
+// them together on SIGTERM/SIGINT.
+type listenerSupervisor struct {
+	c       *Config
+	servers []*http.Server
+	ready   int32 // 1 once all listeners are up, 0 again once draining starts
+}
+
+func newListenerSupervisor(c *Config) *listenerSupervisor {
+	return &listenerSupervisor{c: c}
+}
+
+// Serve binds addr, retrying a failed bind every c.ReconnectInterval
+// instead of giving up immediately, then runs srv.Serve on it in the
+// background. It returns once the listener is bound, or with the error
+// when c.ReconnectInterval is 0 and the first bind attempt fails.
+func (s *listenerSupervisor) Serve(addr string, srv *http.Server, wrap func(net.Listener) (net.Listener, error)) error {
+	lc := net.ListenConfig{}
+	if s.c.ReusePort {
+		lc = reuseportListenConfig()
+	}
+	var l net.Listener
+	var err error
+	for {
+		l, err = lc.Listen(context.Background(), "tcp", addr)
+		if err == nil {
+			break
+		}
+		if s.c.ReconnectInterval <= 0 {
+			return err
+		}
+		s.c.errorLogger().Printf("listen %s: %v, retrying in %s", addr, err, s.c.ReconnectInterval)
+		time.Sleep(s.c.ReconnectInterval)
+	}
+	if wrap != nil {
+		l, err = wrap(l)
+		if err != nil {
+			return err
+		}
+	}
+	s.servers = append(s.servers, srv)
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			s.c.errorLogger().Printf("serve %s: %v", addr, err)
+		}
+	}()
+	return nil
+}
+
+// Ready reports whether the supervisor is accepting requests, i.e.
+// MarkReady has been called and draining has not started. It backs the
+// /ready endpoint.
+func (s *listenerSupervisor) Ready() bool {
+	return atomic.LoadInt32(&s.ready) == 1
+}
+
+// MarkReady flips the health/ready endpoint to "ready". Call it once
+// every listener has been started successfully.
+func (s *listenerSupervisor) MarkReady() {
+	atomic.StoreInt32(&s.ready, 1)
+}
+
+// WaitForShutdown blocks until SIGTERM or SIGINT is received, then
+// flips /ready to "not ready" and drains every server with
+// http.Server.Shutdown, bounded by c.ShutdownTimeout.
+func (s *listenerSupervisor) WaitForShutdown() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGTERM, syscall.SIGINT)
+	<-ch
+	atomic.StoreInt32(&s.ready, 0)
+	ctx := context.Background()
+	if s.c.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.c.ShutdownTimeout)
+		defer cancel()
+	}
+	for _, srv := range s.servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			s.c.errorLogger().Printf("shutdown: %v", err)
+		}
+	}
+}
+
+// readyHandler serves the health/ready endpoint exposed on
+// c.InternalServerAddr: 200 while accepting requests, 503 before
+// MarkReady and while draining.
+func (s *listenerSupervisor) readyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.Ready() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}
+}
+
+// ServeInternalHealth registers readyHandler on mux at /ready and
+// starts serving it on c.InternalServerAddr via Serve, so operators
+// running freegeoip under Kubernetes have a real readiness probe
+// target. It's a no-op if c.InternalServerAddr is empty. Callers that
+// already serve metrics/pprof on the same mux should pass it in here
+// instead of calling this twice.
+func (s *listenerSupervisor) ServeInternalHealth(mux *http.ServeMux) error {
+	if s.c.InternalServerAddr == "" {
+		return nil
+	}
+	mux.HandleFunc("/ready", s.readyHandler())
+	srv := &http.Server{Handler: mux}
+	var wrap func(net.Listener) (net.Listener, error)
+	if s.c.InternalTLS.Enabled() {
+		tlsCfg, err := s.c.InternalTLS.Build(s.c.LetsEncryptHosts)
+		if err != nil {
+			return err
+		}
+		wrap = func(l net.Listener) (net.Listener, error) {
+			return tls.NewListener(l, tlsCfg), nil
+		}
+	}
+	return s.Serve(s.c.InternalServerAddr, srv, wrap)
+}
@@ -0,0 +1,201 @@
+// Copyright 2009 The freegeoip authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package apiserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"flag"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// TLSConfig describes the TLS settings for one listener (the public
+// API or InternalServerAddr), modeled after the usual server/client/
+// peer TLS object used elsewhere for mTLS setups.
+type TLSConfig struct {
+	CertFile   string
+	KeyFile    string
+	CAFile     string // optional; enables client certificate verification (mTLS) when set, or when ClientAuth requires it
+	ClientAuth string // none, request, require, or verify
+	AutoCerts  bool   // generate an in-memory self-signed cert for local dev instead of reading CertFile/KeyFile
+	MinVersion string // 1.0, 1.1, 1.2, or 1.3
+	Ciphers    string // comma separated cipher suite names; empty uses Go's default preferences
+}
+
+// AddFlags adds this TLSConfig's flags to fs, each named "-prefix.*",
+// e.g. AddFlags(fs, "tls") registers -tls.cert, -tls.key, and so on.
+func (t *TLSConfig) AddFlags(fs *flag.FlagSet, prefix string) {
+	fs.StringVar(&t.CertFile, prefix+".cert", t.CertFile, "X.509 certificate file")
+	fs.StringVar(&t.KeyFile, prefix+".key", t.KeyFile, "X.509 key file")
+	fs.StringVar(&t.CAFile, prefix+".ca", t.CAFile, "X.509 CA bundle used to verify client certificates (enables mTLS)")
+	fs.StringVar(&t.ClientAuth, prefix+".client-auth", t.ClientAuth, "Client certificate policy: none, request, require, or verify")
+	fs.BoolVar(&t.AutoCerts, prefix+".auto-certs", t.AutoCerts, "Generate an in-memory self-signed cert for local development instead of loading cert/key files")
+	fs.StringVar(&t.MinVersion, prefix+".min-version", t.MinVersion, "Minimum TLS version: 1.0, 1.1, 1.2, or 1.3")
+	fs.StringVar(&t.Ciphers, prefix+".ciphers", t.Ciphers, "Comma separated cipher suite names to allow; empty uses Go's defaults")
+}
+
+// Enabled reports whether this TLSConfig has enough information to
+// build a *tls.Config.
+func (t *TLSConfig) Enabled() bool {
+	return t.AutoCerts || (t.CertFile != "" && t.KeyFile != "")
+}
+
+// Build constructs a *tls.Config from t. hosts is a comma separated
+// list of SANs used only in AutoCerts mode.
+func (t *TLSConfig) Build(hosts string) (*tls.Config, error) {
+	cfg := &tls.Config{}
+
+	var cert tls.Certificate
+	var err error
+	if t.AutoCerts {
+		cert, err = selfSignedCert(hosts)
+	} else {
+		cert, err = tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("apiserver: failed to load TLS cert/key: %v", err)
+	}
+	cfg.Certificates = []tls.Certificate{cert}
+
+	minVersion, err := tlsVersion(t.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	cfg.MinVersion = minVersion
+
+	if t.Ciphers != "" {
+		suites, err := tlsCipherSuites(t.Ciphers)
+		if err != nil {
+			return nil, err
+		}
+		cfg.CipherSuites = suites
+	}
+
+	clientAuth, err := tlsClientAuthType(t.ClientAuth)
+	if err != nil {
+		return nil, err
+	}
+	if t.CAFile != "" {
+		pool := x509.NewCertPool()
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("apiserver: failed to read CA file %q: %v", t.CAFile, err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("apiserver: no certificates found in CA file %q", t.CAFile)
+		}
+		cfg.ClientCAs = pool
+		if clientAuth == tls.NoClientCert {
+			clientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	cfg.ClientAuth = clientAuth
+
+	return cfg, nil
+}
+
+func tlsVersion(v string) (uint16, error) {
+	switch v {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("apiserver: unknown TLS min version %q", v)
+	}
+}
+
+func tlsClientAuthType(v string) (tls.ClientAuthType, error) {
+	switch v {
+	case "", "none":
+		return tls.NoClientCert, nil
+	case "request":
+		return tls.RequestClientCert, nil
+	case "require":
+		return tls.RequireAnyClientCert, nil
+	case "verify":
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return 0, fmt.Errorf("apiserver: unknown TLS client-auth %q", v)
+	}
+}
+
+var tlsCipherSuiteByName = func() map[string]uint16 {
+	m := make(map[string]uint16)
+	for _, s := range tls.CipherSuites() {
+		m[s.Name] = s.ID
+	}
+	return m
+}()
+
+func tlsCipherSuites(names string) ([]uint16, error) {
+	var suites []uint16
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		id, ok := tlsCipherSuiteByName[name]
+		if !ok {
+			return nil, fmt.Errorf("apiserver: unknown TLS cipher suite %q", name)
+		}
+		suites = append(suites, id)
+	}
+	return suites, nil
+}
+
+// selfSignedCert generates an in-memory self-signed certificate for
+// local development, with SANs derived from the given comma separated
+// hosts (falling back to "localhost").
+func selfSignedCert(hosts string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"freegeoip dev"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	names := strings.Split(hosts, ",")
+	if hosts == "" {
+		names = []string{"localhost"}
+	}
+	for _, h := range names {
+		h = strings.TrimSpace(h)
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else if h != "" {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}, nil
+}